@@ -1,18 +1,29 @@
 package static
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	"embed"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	platform "github.com/influxdata/influxdb/v2"
+	"gopkg.in/yaml.v3"
 )
 
 //go:embed data/*
@@ -32,42 +43,649 @@ const (
 	// reside.
 	uiBaseDir = "build"
 
-	// swaggerFile is the name of the swagger JSON.
+	// swaggerFile is the name of the default (v2) swagger spec.
 	swaggerFile = "swagger.json"
+
+	// swaggerUIDir is the embedded directory holding a bundled Swagger UI,
+	// if one was shipped with the build. Serving it is optional: if this
+	// directory isn't present, NewSwaggerHandler serves specs only.
+	swaggerUIDir = "swagger-ui"
 )
 
+// compressionExt maps the token used in the Accept-Encoding / Content-Encoding
+// headers to the file extension a precompressed sibling asset is published
+// under, e.g. "app.js" ships alongside "app.js.br" and "app.js.gz".
+var compressionExt = map[string]string{
+	"br":   ".br",
+	"gzip": ".gz",
+}
+
+// preferredEncodings lists encodings in the order we prefer them when more
+// than one precompressed variant is available and acceptable to the client.
+var preferredEncodings = []string{"br", "gzip"}
+
+// cspNoncePlaceholder is the token operators embed in a
+// SecurityHeaders.ContentSecurityPolicy string wherever the per-request
+// nonce should be substituted.
+const cspNoncePlaceholder = "{{.Nonce}}"
+
+// SecurityHeaders configures the security-related response headers added to
+// every asset response. The zero value disables the feature entirely; use
+// DefaultSecurityHeaders for sane defaults tuned for the InfluxDB UI, and
+// override individual fields from server config as needed.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is the Content-Security-Policy header value. Any
+	// occurrence of cspNoncePlaceholder is replaced with a fresh per-request
+	// nonce, which is also threaded into index.html so inline scripts can be
+	// allow-listed without relying on 'unsafe-inline'.
+	ContentSecurityPolicy   string
+	XFrameOptions           string
+	ReferrerPolicy          string
+	ContentTypeOptions      string
+	StrictTransportSecurity string
+	PermissionsPolicy       string
+}
+
+// DefaultSecurityHeaders returns security headers tuned for the embedded UI.
+// NewAssetHandler applies them only when passed via WithSecurityHeaders;
+// without that option no security headers are set.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		ContentSecurityPolicy: "default-src 'self'; script-src 'self' 'nonce-" + cspNoncePlaceholder + "'; " +
+			"style-src 'self' 'unsafe-inline'; img-src 'self' data:; font-src 'self' data:; " +
+			"connect-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		XFrameOptions:           "DENY",
+		ReferrerPolicy:          "strict-origin-when-cross-origin",
+		ContentTypeOptions:      "nosniff",
+		StrictTransportSecurity: "max-age=31536000; includeSubDomains",
+		PermissionsPolicy:       "geolocation=(), microphone=(), camera=()",
+	}
+}
+
+// AssetHandlerOption configures the handler returned by NewAssetHandler.
+type AssetHandlerOption func(*assetHandlerConfig)
+
+// assetHandlerConfig holds the options accumulated from the
+// AssetHandlerOption values passed to NewAssetHandler.
+type assetHandlerConfig struct {
+	security *SecurityHeaders
+	overlay  []fs.FS
+	browse   bool
+}
+
+// WithSecurityHeaders enables the security headers middleware, including CSP
+// nonce substitution into index.html, using the provided configuration.
+func WithSecurityHeaders(headers SecurityHeaders) AssetHandlerOption {
+	return func(c *assetHandlerConfig) {
+		c.security = &headers
+	}
+}
+
+// WithOverlay adds one or more fs.FS layers that are searched, in the order
+// given, before the base asset tree (the embedded UI build, or the
+// --assets-path directory). A layer can replace or add files - a custom
+// favicon, logo, theme CSS, or additional JS bundles for plugin UIs - which
+// lets downstream distributors and enterprise builds rebrand or inject
+// plugin panels without forking the binary. Layers passed across multiple
+// WithOverlay calls are searched in the order the options were given.
+//
+// Overlays are an AssetHandlerOption rather than a NewAssetHandler parameter
+// so they compose with --assets-path and the other options (security
+// headers, browse) instead of replacing them; existing callers of
+// NewAssetHandler(assetsPath, opts...) are unaffected by a build that adds
+// overlays.
+func WithOverlay(layers ...fs.FS) AssetHandlerOption {
+	return func(c *assetHandlerConfig) {
+		c.overlay = append(c.overlay, layers...)
+	}
+}
+
+// WithBrowse enables an opt-in JSON directory-listing API: a request for a
+// directory path with "Accept: application/json" gets a JSON array of
+// {name, size, url, mod_time, mode, is_dir, is_symlink} entries describing
+// the union of every layer's contents at that path, instead of falling
+// through to index.html. Useful for tooling that needs to inspect which UI
+// bundles or plugin drops are present without a separate endpoint. The SPA
+// fallback remains the default for every other request.
+func WithBrowse(enabled bool) AssetHandlerOption {
+	return func(c *assetHandlerConfig) {
+		c.browse = enabled
+	}
+}
+
 // NewAssetHandler returns an http.Handler to serve files from the provided
 // path. If an empty string is provided as the path, the files are served from
 // the embedded assets.
-func NewAssetHandler(assetsPath string) http.Handler {
-	var a http.Handler
+func NewAssetHandler(assetsPath string, opts ...AssetHandlerOption) http.Handler {
+	var cfg assetHandlerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
 
-	if assetsPath != "" {
-		a = assetHandler(os.DirFS(assetsPath), "")
-	} else {
-		a = assetHandler(data, filepath.Join(embedBaseDir, uiBaseDir))
+	base, err := baseAssetFS(assetsPath)
+	if err != nil {
+		return mwSetCacheControl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		}))
+	}
+
+	layers := make([]assetLayer, 0, len(cfg.overlay)+1)
+	for _, l := range cfg.overlay {
+		layers = append(layers, newAssetLayer(l))
+	}
+	layers = append(layers, newAssetLayer(base))
+
+	var indexTemplate *template.Template
+	if cfg.security != nil {
+		indexTemplate = parseIndexTemplate(layers)
+	}
+
+	a := assetHandler(layers, indexTemplate, cfg.browse)
+	if cfg.security != nil {
+		a = mwSecurityHeaders(a, *cfg.security)
 	}
 
 	return mwSetCacheControl(a)
 }
 
-// NewSwagger returns an http.Handler to serve the swaggerFile from the
-// embedBaseDir. If the swaggerFile is not found, returns a 404.
-func NewSwaggerHandler() http.Handler {
+// baseAssetFS returns the lowest-priority asset layer: the --assets-path
+// directory if one was given, or the embedded UI build otherwise. It's
+// rooted directly at the asset tree (via fs.Sub for the embedded case) so
+// every layer - overlay or base - can be treated identically by the rest of
+// this package.
+func baseAssetFS(assetsPath string) (fs.FS, error) {
+	if assetsPath != "" {
+		return os.DirFS(assetsPath), nil
+	}
+	return fs.Sub(data, filepath.Join(embedBaseDir, uiBaseDir))
+}
+
+// parseIndexTemplate reads defaultFile from the first layer that has it, once
+// at startup, and compiles it as an html/template, so that per-request nonce
+// substitution doesn't re-parse the file on every hit. Returns nil if the
+// index file can't be found in any layer, in which case index.html is served
+// verbatim.
+func parseIndexTemplate(layers []assetLayer) *template.Template {
+	b, err := readAsset(layers, defaultFile)
+	if err != nil {
+		return nil
+	}
+
+	t, err := template.New(defaultFile).Parse(string(b))
+	if err != nil {
+		return nil
+	}
+
+	return t
+}
+
+// readAsset reads name from the first layer that has it.
+func readAsset(layers []assetLayer, name string) ([]byte, error) {
+	for _, l := range layers {
+		if b, err := fs.ReadFile(l.fs, name); err == nil {
+			return b, nil
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// serveIndexTemplate executes indexTemplate with the request's CSP nonce and
+// writes the result directly, bypassing the asset cache since the output
+// differs on every request.
+func serveIndexTemplate(indexTemplate *template.Template, w http.ResponseWriter, r *http.Request) {
+	nonce, _ := r.Context().Value(nonceContextKey{}).(string)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if err := indexTemplate.Execute(w, struct{ Nonce string }{Nonce: nonce}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// nonceContextKey is the context key under which mwSecurityHeaders stores the
+// per-request CSP nonce.
+type nonceContextKey struct{}
+
+// newNonce returns a fresh base64-encoded random nonce suitable for use in a
+// Content-Security-Policy header. It uses RawURLEncoding (no "+", "/", or
+// "=") rather than StdEncoding so the nonce is byte-identical whether it's
+// substituted verbatim into the CSP header or HTML-escaped into index.html
+// by html/template - StdEncoding's "+" would otherwise round-trip to
+// "&#43;" in the HTML attribute, relying on the browser to decode it back.
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// mwSecurityHeaders sets security-related response headers, generating a
+// fresh nonce for each request and substituting it into
+// SecurityHeaders.ContentSecurityPolicy. The nonce is also stashed on the
+// request context so assetHandler can thread it into index.html.
+func mwSecurityHeaders(next http.Handler, headers SecurityHeaders) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		f, err := data.Open(filepath.Join(embedBaseDir, swaggerFile))
+		nonce, err := newNonce()
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		defer f.Close()
 
-		staticFileHandler(f).ServeHTTP(w, r)
+		h := w.Header()
+		if headers.ContentSecurityPolicy != "" {
+			h.Set("Content-Security-Policy", strings.ReplaceAll(headers.ContentSecurityPolicy, cspNoncePlaceholder, nonce))
+		}
+		if headers.XFrameOptions != "" {
+			h.Set("X-Frame-Options", headers.XFrameOptions)
+		}
+		if headers.ReferrerPolicy != "" {
+			h.Set("Referrer-Policy", headers.ReferrerPolicy)
+		}
+		if headers.ContentTypeOptions != "" {
+			h.Set("X-Content-Type-Options", headers.ContentTypeOptions)
+		}
+		if headers.StrictTransportSecurity != "" {
+			h.Set("Strict-Transport-Security", headers.StrictTransportSecurity)
+		}
+		if headers.PermissionsPolicy != "" {
+			h.Set("Permissions-Policy", headers.PermissionsPolicy)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nonceContextKey{}, nonce)))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// SwaggerVersion describes one servable OpenAPI spec version.
+type SwaggerVersion struct {
+	// Name addresses this spec under /swagger/<Name>.json (or .yaml), e.g.
+	// "v2" serves at /swagger/v2.json and /swagger/v2.yaml.
+	Name string
+	// File is the spec's path within the embedded data directory.
+	File string
+	// Tags, if non-empty, restricts the served spec to operations carrying
+	// at least one of these OpenAPI tags - e.g. to produce an OSS variant
+	// that omits enterprise-only endpoints.
+	Tags []string
+}
+
+// defaultSwaggerVersions is served by NewSwaggerHandler when no
+// WithSwaggerVersion options are given.
+var defaultSwaggerVersions = []SwaggerVersion{
+	{Name: "v2", File: swaggerFile},
+}
+
+// SwaggerHandlerOption configures the handler returned by NewSwaggerHandler.
+type SwaggerHandlerOption func(*swaggerHandlerConfig)
+
+// swaggerHandlerConfig holds the options accumulated from the
+// SwaggerHandlerOption values passed to NewSwaggerHandler.
+type swaggerHandlerConfig struct {
+	versions []SwaggerVersion
+}
+
+// WithSwaggerVersion registers a spec version to serve. Passing this at
+// least once replaces the default "v2" list entirely - callers that want v2
+// alongside their own versions must list it explicitly
+// (WithSwaggerVersion(SwaggerVersion{Name: "v2", File: swaggerFile})). A
+// second call reusing an already-registered Name replaces that version
+// rather than serving it twice.
+func WithSwaggerVersion(v SwaggerVersion) SwaggerHandlerOption {
+	return func(c *swaggerHandlerConfig) {
+		for i, existing := range c.versions {
+			if existing.Name == v.Name {
+				c.versions[i] = v
+				return
+			}
+		}
+		c.versions = append(c.versions, v)
+	}
+}
+
+// NewSwaggerHandler returns an http.Handler serving one or more OpenAPI spec
+// versions under /swagger/<version>.json or /swagger/<version>.yaml (the
+// format can also be negotiated via Accept when the URL has no extension),
+// plus the bundled Swagger UI at /swagger/ if swaggerUIDir was embedded. The
+// handler expects to be mounted at the "/swagger" prefix.
+//
+// Every response has the server's actual base URL injected into the spec's
+// servers[], and rendered bytes are cached per (version, format, base URL)
+// with a strong content-hash ETag so repeated fetches by codegen tools are
+// cheap.
+func NewSwaggerHandler(opts ...SwaggerHandlerOption) http.Handler {
+	var cfg swaggerHandlerConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if len(cfg.versions) == 0 {
+		cfg.versions = defaultSwaggerVersions
+	}
+
+	specs := make(map[string]*swaggerSpec, len(cfg.versions))
+	tagsByVersion := make(map[string][]string, len(cfg.versions))
+	for _, v := range cfg.versions {
+		spec, err := loadSwaggerSpec(v)
+		if err != nil {
+			// A misconfigured version is dropped rather than failing the
+			// whole handler - the remaining versions still serve fine.
+			continue
+		}
+		specs[v.Name] = spec
+		tagsByVersion[v.Name] = v.Tags
+	}
+
+	uiLayer, hasUI := newSwaggerUILayer()
+	uiCache := newAssetMetaCache()
+	renderCache := newSwaggerRenderCache()
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		name = strings.TrimPrefix(name, "swagger")
+		name = strings.TrimPrefix(name, "/")
+
+		if name == "" {
+			// The UI root ("/swagger" or "/swagger/").
+			if hasUI {
+				serveSwaggerUIAsset(uiLayer, uiCache, defaultFile, w, r)
+				return
+			}
+			http.NotFound(w, r)
+			return
+		}
+
+		version, format := splitSwaggerName(name)
+
+		spec, ok := specs[version]
+		if !ok {
+			// Not a recognized "<version>.<ext>" path - treat it as one of
+			// the UI's own JS/CSS/image assets instead.
+			if hasUI {
+				serveSwaggerUIAsset(uiLayer, uiCache, name, w, r)
+				return
+			}
+			http.Error(w, fmt.Sprintf("unknown swagger version %q", version), http.StatusNotFound)
+			return
+		}
+
+		if format == "" {
+			format = negotiateSwaggerFormat(r)
+		}
+
+		baseURL := requestBaseURL(r)
+		key := version + "|" + format + "|" + baseURL
+
+		rendered, err := renderCache.render(spec, key, baseURL, tagsByVersion[version], format)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveRenderedSpec(w, r, name, rendered)
 	}
 
 	return mwSetCacheControl(http.HandlerFunc(fn))
 }
 
+// swaggerSpec holds a spec's raw, unmodified bytes. Each render re-parses
+// from raw rather than mutating a shared decoded copy, so concurrent
+// requests for different base URLs/tag sets never interfere.
+type swaggerSpec struct {
+	raw []byte
+}
+
+// loadSwaggerSpec reads v.File from the embedded data directory.
+func loadSwaggerSpec(v SwaggerVersion) (*swaggerSpec, error) {
+	b, err := data.ReadFile(filepath.Join(embedBaseDir, v.File))
+	if err != nil {
+		return nil, err
+	}
+	return &swaggerSpec{raw: b}, nil
+}
+
+// render decodes the spec, injects baseURL into servers[], restricts paths
+// to operations tagged with one of tags (if any are given), and encodes the
+// result as JSON or YAML.
+func (s *swaggerSpec) render(baseURL string, tags []string, format string) ([]byte, string, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(s.raw, &doc); err != nil {
+		return nil, "", err
+	}
+
+	if baseURL != "" {
+		doc["servers"] = []map[string]string{{"url": baseURL}}
+	}
+
+	if len(tags) > 0 {
+		filterPathsByTag(doc, tags)
+	}
+
+	if format == "yaml" {
+		b, err := yaml.Marshal(doc)
+		return b, "application/yaml; charset=utf-8", err
+	}
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	return b, "application/json; charset=utf-8", err
+}
+
+// openAPIMethodKeys are the path-item keys that represent operations, per
+// the OpenAPI spec. Every other key ("parameters", "servers", "summary",
+// "description", "$ref", ...) describes the path itself and must survive tag
+// filtering untouched.
+var openAPIMethodKeys = map[string]bool{
+	"get": true, "put": true, "post": true, "delete": true,
+	"options": true, "head": true, "patch": true, "trace": true,
+}
+
+// filterPathsByTag removes any path operation whose "tags" array doesn't
+// intersect allowed, so e.g. an OSS build's spec can omit enterprise-only
+// endpoints. A path left with no operations (ignoring non-operation keys
+// like "parameters") is dropped entirely.
+func filterPathsByTag(doc map[string]interface{}, allowed []string) {
+	paths, ok := doc["paths"].(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	allow := make(map[string]bool, len(allowed))
+	for _, t := range allowed {
+		allow[t] = true
+	}
+
+	for p, v := range paths {
+		item, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		remaining := 0
+		for key := range item {
+			if !openAPIMethodKeys[key] {
+				continue
+			}
+			op, ok := item[key].(map[string]interface{})
+			if !ok || !operationHasTag(op, allow) {
+				delete(item, key)
+				continue
+			}
+			remaining++
+		}
+
+		if remaining == 0 {
+			delete(paths, p)
+		}
+	}
+}
+
+// operationHasTag reports whether op's "tags" array contains any tag in allow.
+func operationHasTag(op map[string]interface{}, allow map[string]bool) bool {
+	tags, ok := op["tags"].([]interface{})
+	if !ok {
+		return false
+	}
+	for _, t := range tags {
+		if s, ok := t.(string); ok && allow[s] {
+			return true
+		}
+	}
+	return false
+}
+
+// requestBaseURL derives the externally visible base URL for r, honoring
+// X-Forwarded-Proto for requests arriving through a reverse proxy.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// negotiateSwaggerFormat returns "yaml" if the Accept header lists a YAML
+// media type anywhere, and "json" otherwise. q-values (including q=0) and the
+// order of the listed types are not considered.
+func negotiateSwaggerFormat(r *http.Request) string {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		switch strings.TrimSpace(strings.SplitN(part, ";", 2)[0]) {
+		case "application/yaml", "application/x-yaml", "text/yaml":
+			return "yaml"
+		}
+	}
+	return "json"
+}
+
+// splitSwaggerName splits a request path segment like "v2.json" into its
+// version ("v2") and an explicit format ("json"). format is "" if name has
+// no recognized spec extension, in which case the caller should negotiate
+// the format from the Accept header instead.
+func splitSwaggerName(name string) (version, format string) {
+	switch {
+	case strings.HasSuffix(name, ".json"):
+		return strings.TrimSuffix(name, ".json"), "json"
+	case strings.HasSuffix(name, ".yaml"):
+		return strings.TrimSuffix(name, ".yaml"), "yaml"
+	case strings.HasSuffix(name, ".yml"):
+		return strings.TrimSuffix(name, ".yml"), "yaml"
+	default:
+		return name, ""
+	}
+}
+
+// renderedSpec is a version's rendered bytes for one (format, base URL) pair.
+type renderedSpec struct {
+	body        []byte
+	etag        string
+	contentType string
+}
+
+// maxSwaggerRenderCacheEntries bounds swaggerRenderCache. Its key includes
+// the request's base URL (requestBaseURL), which is derived from the
+// client-controlled Host and X-Forwarded-Proto headers, so without a bound a
+// client varying those headers could grow the cache without limit. The
+// cache is just a performance optimization, so clearing it on overflow is
+// harmless - it only costs a re-render of whatever's requested next.
+const maxSwaggerRenderCacheEntries = 256
+
+// swaggerRenderCache memoizes renderedSpec by a (version, format, base URL)
+// key, so repeated fetches by codegen tools against the same host skip
+// re-rendering the spec.
+type swaggerRenderCache struct {
+	mu    sync.Mutex
+	byKey map[string]renderedSpec
+}
+
+func newSwaggerRenderCache() *swaggerRenderCache {
+	return &swaggerRenderCache{byKey: make(map[string]renderedSpec)}
+}
+
+func (c *swaggerRenderCache) render(spec *swaggerSpec, key, baseURL string, tags []string, format string) (renderedSpec, error) {
+	c.mu.Lock()
+	rs, ok := c.byKey[key]
+	c.mu.Unlock()
+	if ok {
+		return rs, nil
+	}
+
+	body, contentType, err := spec.render(baseURL, tags, format)
+	if err != nil {
+		return renderedSpec{}, err
+	}
+
+	sum := sha256.Sum256(body)
+	rs = renderedSpec{
+		body:        body,
+		etag:        fmt.Sprintf(`"%x"`, sum),
+		contentType: contentType,
+	}
+
+	c.mu.Lock()
+	if len(c.byKey) >= maxSwaggerRenderCacheEntries {
+		c.byKey = make(map[string]renderedSpec)
+	}
+	c.byKey[key] = rs
+	c.mu.Unlock()
+
+	return rs, nil
+}
+
+// serveRenderedSpec honors If-None-Match against rs's strong ETag before
+// falling back to http.ServeContent.
+func serveRenderedSpec(w http.ResponseWriter, r *http.Request, name string, rs renderedSpec) {
+	w.Header().Set("Content-Type", rs.contentType)
+	w.Header().Set("ETag", rs.etag)
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, rs.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(rs.body))
+}
+
+// newSwaggerUILayer wraps swaggerUIDir as an assetLayer if it was embedded
+// alongside the specs, so the rest of the handler can reuse resolveAsset and
+// serveAsset exactly as the UI bundle served by NewAssetHandler does. ok is
+// false if no UI was shipped with this build, in which case only the specs
+// are served.
+func newSwaggerUILayer() (layer assetLayer, ok bool) {
+	sub, err := fs.Sub(data, filepath.Join(embedBaseDir, swaggerUIDir))
+	if err != nil {
+		return assetLayer{}, false
+	}
+	if _, err := fs.Stat(sub, defaultFile); err != nil {
+		return assetLayer{}, false
+	}
+	return newAssetLayer(sub), true
+}
+
+// serveSwaggerUIAsset serves name from the Swagger UI layer (the caller
+// passes defaultFile for the UI root). A name the layer doesn't contain gets
+// a 404; there is no fallback to defaultFile.
+func serveSwaggerUIAsset(layer assetLayer, cache *assetMetaCache, name string, w http.ResponseWriter, r *http.Request) {
+	layers := []assetLayer{layer}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	f, key, enc, err := resolveAsset(layers, name, r.Header.Get("Accept-Encoding"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	if enc != "" {
+		w.Header().Set("Content-Encoding", enc)
+	}
+
+	serveAsset(cache, key, path.Base(name), f, w, r)
+}
+
 // mwSetCacheControl sets a default cache control header.
 func mwSetCacheControl(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
@@ -77,102 +695,396 @@ func mwSetCacheControl(next http.Handler) http.Handler {
 	return http.HandlerFunc(fn)
 }
 
-// assetHandler takes an fs.FS and a dir name and returns a handler that either
-// serves the file at that path, or the default file if a file cannot be found
-// at that path. An empty string can be provided for dir if the files are not
-// located in a subdirectory, which is the case when using an --assets-path
-// flag.
-func assetHandler(fileOpener fs.FS, dir string) http.Handler {
+// assetLayer pairs an fs.FS rooted at an asset tree with its precomputed
+// compression index, so resolveAsset can do an O(1) map lookup per request
+// instead of re-statting sibling ".br"/".gz" files.
+type assetLayer struct {
+	fs         fs.FS
+	compressed map[string]map[string]bool
+}
+
+// newAssetLayer indexes fsys once via buildCompressionIndex.
+func newAssetLayer(fsys fs.FS) assetLayer {
+	return assetLayer{fs: fsys, compressed: buildCompressionIndex(fsys)}
+}
+
+// assetHandler returns a handler that searches layers, in priority order,
+// for the requested asset and serves the first match, falling back to
+// defaultFile (itself searched across all layers) if no layer has it - which
+// is how a SPA's client-side routes get served. Callers always include the
+// embedded/--assets-path tree as the lowest-priority layer.
+func assetHandler(layers []assetLayer, indexTemplate *template.Template, browse bool) http.Handler {
+	cache := newAssetMetaCache()
+
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		name := strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+
+		if browse && acceptsJSON(r) {
+			if entries, ok := listDir(layers, name); ok {
+				writeDirListing(w, entries)
+				return
+			}
+		}
+
 		// If the root directory is being requested, respond with the default file.
 		if name == "" {
 			name = defaultFile
 		}
 
-		// Try to open the file requested by name, falling back to the default file.
-		// If even the default file can't be found, the binary must not have been
-		// built with assets, so respond with not found.
-		f, err := openAsset(fileOpener, dir, name)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+		// index.html gets a fresh nonce substituted into it on every request
+		// when security headers are enabled, so it can't be served from the
+		// content-hash cache below - it's never byte-for-byte identical twice.
+		if name == defaultFile && indexTemplate != nil {
+			serveIndexTemplate(indexTemplate, w, r)
 			return
 		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		// Try to open the asset requested by name, falling back to the default
+		// file. If even the default file can't be found in any layer, the
+		// binary must not have been built with assets, so respond with not found.
+		f, key, enc, err := resolveAsset(layers, name, r.Header.Get("Accept-Encoding"))
+		if err != nil {
+			// The SPA fallback serves index.html too, so it needs the nonce
+			// substituted just like a direct request for it.
+			if indexTemplate != nil {
+				serveIndexTemplate(indexTemplate, w, r)
+				return
+			}
+			f, key, enc, err = resolveAsset(layers, defaultFile, r.Header.Get("Accept-Encoding"))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
 		defer f.Close()
 
-		staticFileHandler(f).ServeHTTP(w, r)
+		if enc != "" {
+			w.Header().Set("Content-Encoding", enc)
+		}
+
+		// The Content-Type is always derived from the original (not the
+		// compressed) asset name, so clients see the same identity regardless
+		// of which encoding was negotiated, while the cache key identifies the
+		// exact (layer, file) pair actually opened.
+		serveAsset(cache, key, path.Base(name), f, w, r)
 	}
 
 	return http.HandlerFunc(fn)
 }
 
-// staticFileHandler takes the provided fs.File and sets the ETag header prior
-// to calling http.ServeContent with the contents of the file.
-func staticFileHandler(f fs.File) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		content, ok := f.(io.ReadSeeker)
-		if !ok {
-			err := fmt.Errorf("could not open file for reading")
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+// resolveAsset searches layers in order for name, opening a precompressed
+// sibling when the layer has one and acceptEncoding allows it. It returns the
+// opened file, a cache key unique to the (layer, file) pair, and the
+// negotiated encoding token (empty string if serving uncompressed).
+func resolveAsset(layers []assetLayer, name, acceptEncoding string) (f fs.File, key, encoding string, err error) {
+	for i, l := range layers {
+		if enc := negotiateEncoding(acceptEncoding, l.compressed[name]); enc != "" {
+			compressedName := name + compressionExt[enc]
+			if f, err := l.fs.Open(compressedName); err == nil {
+				return f, fmt.Sprintf("%d:%s", i, compressedName), enc, nil
+			}
 		}
 
-		i, err := f.Stat()
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		if f, err := l.fs.Open(name); err == nil {
+			return f, fmt.Sprintf("%d:%s", i, name), "", nil
+		}
+	}
+
+	return nil, "", "", fs.ErrNotExist
+}
+
+// buildCompressionIndex walks fileOpener once and records, per asset, which
+// precompressed encodings (.br, .gz) are available as sibling files.
+func buildCompressionIndex(fileOpener fs.FS) map[string]map[string]bool {
+	idx := make(map[string]map[string]bool)
+
+	_ = fs.WalkDir(fileOpener, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
 		}
 
-		modTime, err := modTimeFromInfo(i)
+		for enc, ext := range compressionExt {
+			if !strings.HasSuffix(p, ext) {
+				continue
+			}
+
+			name := strings.TrimSuffix(p, ext)
+			if idx[name] == nil {
+				idx[name] = make(map[string]bool)
+			}
+			idx[name][enc] = true
+		}
+
+		return nil
+	})
+
+	return idx
+}
+
+// assetEntry describes one file or subdirectory returned by the browse API.
+type assetEntry struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	URL       string    `json:"url"`
+	ModTime   time.Time `json:"mod_time"`
+	Mode      string    `json:"mode"`
+	IsDir     bool      `json:"is_dir"`
+	IsSymlink bool      `json:"is_symlink"`
+}
+
+// acceptsJSON reports whether the request's Accept header names
+// "application/json" as one of its media types.
+func acceptsJSON(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		if strings.TrimSpace(strings.SplitN(part, ";", 2)[0]) == "application/json" {
+			return true
+		}
+	}
+	return false
+}
+
+// listDir lists dir merged across layers in priority order. When the same
+// name appears in more than one layer, only the highest-priority layer's
+// entry is kept. ok is false if dir isn't a directory in any layer, which
+// tells the caller to fall through to normal asset serving.
+func listDir(layers []assetLayer, dir string) (entries []assetEntry, ok bool) {
+	root := dir
+	if root == "" {
+		root = "."
+	}
+
+	entries = []assetEntry{}
+	seen := make(map[string]bool)
+	for _, l := range layers {
+		des, err := fs.ReadDir(l.fs, root)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			continue
 		}
+		ok = true
+
+		for _, de := range des {
+			if seen[de.Name()] {
+				continue
+			}
+			seen[de.Name()] = true
 
-		w.Header().Set("ETag", etag(i.Size(), modTime))
+			info, err := de.Info()
+			if err != nil {
+				continue
+			}
 
-		// ServeContent will automatically set the content-type header for files
-		// from the extension of "name", and will also set the Last-Modified header
-		// from the provided time.
-		http.ServeContent(w, r, i.Name(), modTime, content)
+			entries = append(entries, assetEntry{
+				Name:      de.Name(),
+				Size:      info.Size(),
+				URL:       path.Join("/", dir, de.Name()),
+				ModTime:   info.ModTime(),
+				Mode:      info.Mode().String(),
+				IsDir:     de.IsDir(),
+				IsSymlink: info.Mode()&fs.ModeSymlink != 0,
+			})
+		}
 	}
 
-	return http.HandlerFunc(fn)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	return entries, ok
 }
 
-// openAsset attempts to open the asset by name in the given directory, falling
-// back to the default file if the named asset can't be found. Returns an error
-// if even the default asset can't be opened.
-func openAsset(fileOpener fs.FS, dir, name string) (fs.File, error) {
-	f, err := fileOpener.Open(filepath.Join(dir, name))
-	if err != nil {
-		if os.IsNotExist(err) {
-			f, err = fileOpener.Open(filepath.Join(dir, defaultFile))
+// writeDirListing writes entries as a JSON array.
+func writeDirListing(w http.ResponseWriter, entries []assetEntry) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(w).Encode(entries); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// negotiateEncoding returns the most preferred encoding in available that the
+// client's Accept-Encoding header also accepts, or "" if the client should be
+// served the uncompressed asset. Per RFC 7231 5.3.4, an explicit "q=0"
+// rejects that encoding even if it's otherwise listed or covered by "*", and
+// "*" (when not itself rejected) accepts any encoding not explicitly listed.
+func negotiateEncoding(acceptEncoding string, available map[string]bool) string {
+	if len(available) == 0 {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	wildcardOK, haveWildcard := false, false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		enc, q := parseAcceptEncodingMember(part)
+		if enc == "" {
+			continue
 		}
-		if err != nil {
-			return nil, err
+		if enc == "*" {
+			wildcardOK, haveWildcard = q != 0, true
+			continue
+		}
+		accepted[enc] = q != 0
+	}
+
+	for _, enc := range preferredEncodings {
+		if !available[enc] {
+			continue
+		}
+		if ok, explicit := accepted[enc]; explicit {
+			if ok {
+				return enc
+			}
+			continue
+		}
+		if haveWildcard && wildcardOK {
+			return enc
+		}
+	}
+
+	return ""
+}
+
+// parseAcceptEncodingMember splits one comma-separated Accept-Encoding
+// member ("gzip", "gzip;q=0.5", "gzip;q=0") into its token and q-value,
+// defaulting q to 1 when absent or unparseable.
+func parseAcceptEncodingMember(part string) (token string, q float64) {
+	fields := strings.Split(part, ";")
+
+	token = strings.TrimSpace(fields[0])
+	if token == "" {
+		return "", 0
+	}
+
+	q = 1
+	for _, param := range fields[1:] {
+		param = strings.TrimSpace(param)
+		if !strings.HasPrefix(param, "q=") {
+			continue
+		}
+		if parsed, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+			q = parsed
 		}
 	}
 
-	return f, nil
+	return token, q
+}
+
+// assetMeta is the content-addressed metadata cached for an asset after its
+// first open, so that subsequent requests never need to re-hash the file.
+type assetMeta struct {
+	etag        string
+	size        int64
+	modTime     time.Time
+	contentType string
+}
+
+// assetMetaCache memoizes assetMeta by the key resolveAsset returns: the
+// index of the layer plus the path of the file actually opened (which, for a
+// precompressed variant, differs from the name reported to the client). The
+// layer index keeps an overlay's file apart from the base layer's file of the
+// same name. Each handler owns its own cache, since the same key can refer to
+// different content in another handler's layers (e.g. the Swagger UI layer
+// versus the embedded build dir).
+type assetMetaCache struct {
+	mu   sync.Mutex
+	byID map[string]assetMeta
+}
+
+func newAssetMetaCache() *assetMetaCache {
+	return &assetMetaCache{byID: make(map[string]assetMeta)}
 }
 
-// modTimeFromInfo gets the modification time from an fs.FileInfo. If this
-// modification time is zero (for embedded assets), it falls back to the build
-// time for the binary.
-func modTimeFromInfo(i fs.FileInfo) (time.Time, error) {
-	modTime := i.ModTime()
-	var err error
-	if modTime.IsZero() {
-		modTime, err = time.Parse(time.RFC3339, platform.GetBuildInfo().Date)
+// get returns the cached assetMeta for key, computing and caching it from f
+// on first use. displayName is used only to derive the Content-Type, so a
+// precompressed variant reports the type of the asset it stands in for.
+func (c *assetMetaCache) get(key, displayName string, f fs.File) (assetMeta, error) {
+	c.mu.Lock()
+	m, ok := c.byID[key]
+	c.mu.Unlock()
+	if ok {
+		return m, nil
 	}
 
-	return modTime, err
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		return assetMeta{}, fmt.Errorf("could not open file for reading")
+	}
+
+	i, err := f.Stat()
+	if err != nil {
+		return assetMeta{}, err
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return assetMeta{}, err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return assetMeta{}, err
+	}
+
+	m = assetMeta{
+		etag:        fmt.Sprintf(`"%x"`, h.Sum(nil)),
+		size:        i.Size(),
+		modTime:     i.ModTime(),
+		contentType: mime.TypeByExtension(filepath.Ext(displayName)),
+	}
+
+	c.mu.Lock()
+	c.byID[key] = m
+	c.mu.Unlock()
+
+	return m, nil
+}
+
+// serveAsset looks up (or computes) the content-addressed metadata for f,
+// honors If-None-Match by writing a 304 before ServeContent ever touches the
+// body, and otherwise serves the file. If-Modified-Since is left to
+// http.ServeContent, which runs its own precondition check against modTime
+// for proxies that only send that header. Files with a zero modTime (such as
+// the embedded build) get no Last-Modified header, and If-Modified-Since is
+// not checked for them.
+func serveAsset(cache *assetMetaCache, key, displayName string, f fs.File, w http.ResponseWriter, r *http.Request) {
+	content, ok := f.(io.ReadSeeker)
+	if !ok {
+		http.Error(w, "could not open file for reading", http.StatusInternalServerError)
+		return
+	}
+
+	meta, err := cache.get(key, displayName, f)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if meta.contentType != "" {
+		w.Header().Set("Content-Type", meta.contentType)
+	}
+	w.Header().Set("ETag", meta.etag)
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, meta.etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// ServeContent sets Last-Modified from modTime and handles
+	// If-Modified-Since itself, unless modTime is zero.
+	http.ServeContent(w, r, displayName, meta.modTime, content)
 }
 
-// etag calculates an etag string from the provided file size and
-// modification time.
-func etag(s int64, mt time.Time) string {
-	hour, minute, second := mt.Clock()
-	return fmt.Sprintf(`"%d%d%d%d%d"`, s, mt.Day(), hour, minute, second)
+// etagMatches reports whether the If-None-Match header value - which may be
+// "*" or a comma-separated list of quoted (optionally weak, "W/"-prefixed)
+// etags - matches etag.
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == etag {
+			return true
+		}
+	}
+
+	return false
 }
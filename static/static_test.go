@@ -0,0 +1,303 @@
+package static
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	available := map[string]bool{"br": true, "gzip": true}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		available      map[string]bool
+		want           string
+	}{
+		{"no header", "", available, ""},
+		{"no variants available", "gzip, br", nil, ""},
+		{"prefers br over gzip", "gzip, br", available, "br"},
+		{"only gzip accepted", "gzip", available, "gzip"},
+		{"q=0 rejects gzip even though listed", "br;q=0.5, gzip;q=0", available, "br"},
+		{"q=0 rejects every available encoding", "br;q=0, gzip;q=0", available, ""},
+		{"wildcard accepts unlisted encoding", "*", available, "br"},
+		{"explicit q=0 beats wildcard", "*, br;q=0", available, "gzip"},
+		{"wildcard q=0 rejects everything not explicitly accepted", "*;q=0", available, ""},
+		{"wildcard q=0 but explicit accept still wins", "*;q=0, gzip", available, "gzip"},
+		{"unrelated encoding ignored", "identity", available, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateEncoding(tt.acceptEncoding, tt.available); got != tt.want {
+				t.Errorf("negotiateEncoding(%q, %v) = %q, want %q", tt.acceptEncoding, tt.available, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAcceptEncodingMember(t *testing.T) {
+	tests := []struct {
+		part      string
+		wantToken string
+		wantQ     float64
+	}{
+		{"gzip", "gzip", 1},
+		{" gzip ", "gzip", 1},
+		{"gzip;q=0.5", "gzip", 0.5},
+		{"gzip;q=0", "gzip", 0},
+		{"gzip; q=bogus", "gzip", 1},
+		{"", "", 0},
+	}
+
+	for _, tt := range tests {
+		token, q := parseAcceptEncodingMember(tt.part)
+		if token != tt.wantToken || q != tt.wantQ {
+			t.Errorf("parseAcceptEncodingMember(%q) = (%q, %v), want (%q, %v)", tt.part, token, q, tt.wantToken, tt.wantQ)
+		}
+	}
+}
+
+func TestResolveAssetVariantSelection(t *testing.T) {
+	fsys := fstest.MapFS{
+		"app.js":    {Data: []byte("plain")},
+		"app.js.gz": {Data: []byte("gzipped")},
+		"app.js.br": {Data: []byte("brotli")},
+	}
+	layers := []assetLayer{newAssetLayer(fsys)}
+
+	f, _, enc, err := resolveAsset(layers, "app.js", "gzip, br")
+	if err != nil {
+		t.Fatalf("resolveAsset: %v", err)
+	}
+	defer f.Close()
+	if enc != "br" {
+		t.Errorf("encoding = %q, want br (preferred over gzip)", enc)
+	}
+
+	f2, _, enc2, err := resolveAsset(layers, "app.js", "identity")
+	if err != nil {
+		t.Fatalf("resolveAsset: %v", err)
+	}
+	defer f2.Close()
+	if enc2 != "" {
+		t.Errorf("encoding = %q, want \"\" (uncompressed)", enc2)
+	}
+}
+
+func TestAssetHandlerSetsVaryOnAssetRequests(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte("<html></html>")},
+		"app.js":     {Data: []byte("console.log(1)")},
+	}
+	h := assetHandler([]assetLayer{newAssetLayer(fsys)}, nil, false)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+
+	if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary header = %q, want %q", got, "Accept-Encoding")
+	}
+}
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		etag        string
+		want        bool
+	}{
+		{"wildcard always matches", "*", `"abc"`, true},
+		{"exact match", `"abc"`, `"abc"`, true},
+		{"weak match", `W/"abc"`, `"abc"`, true},
+		{"one of several candidates", `"xyz", "abc"`, `"abc"`, true},
+		{"no match", `"xyz"`, `"abc"`, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.ifNoneMatch, tt.etag); got != tt.want {
+			t.Errorf("%s: etagMatches(%q, %q) = %v, want %v", tt.name, tt.ifNoneMatch, tt.etag, got, tt.want)
+		}
+	}
+}
+
+func TestServeAssetHonors304(t *testing.T) {
+	fsys := fstest.MapFS{"app.js": {Data: []byte("console.log(1)")}}
+	h := assetHandler([]assetLayer{newAssetLayer(fsys)}, nil, false)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected an ETag on the first response")
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/app.js", nil)
+	req.Header.Set("If-None-Match", etag)
+	rr2 := httptest.NewRecorder()
+	h.ServeHTTP(rr2, req)
+
+	if rr2.Code != http.StatusNotModified {
+		t.Errorf("status = %d, want %d", rr2.Code, http.StatusNotModified)
+	}
+	if rr2.Body.Len() != 0 {
+		t.Errorf("304 response body should be empty, got %q", rr2.Body.String())
+	}
+}
+
+func TestNonceSubstitutedIntoIndexAndCSPHeader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte(`<script nonce="{{.Nonce}}"></script>`)},
+	}
+	layers := []assetLayer{newAssetLayer(fsys)}
+	tmpl := parseIndexTemplate(layers)
+	if tmpl == nil {
+		t.Fatal("parseIndexTemplate returned nil")
+	}
+
+	security := SecurityHeaders{ContentSecurityPolicy: "script-src 'nonce-" + cspNoncePlaceholder + "'"}
+	h := mwSecurityHeaders(assetHandler(layers, tmpl, false), security)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	csp := rr.Header().Get("Content-Security-Policy")
+	if strings.Contains(csp, cspNoncePlaceholder) {
+		t.Fatalf("CSP header still contains the placeholder: %q", csp)
+	}
+
+	const prefix = "script-src 'nonce-"
+	nonce := strings.TrimSuffix(strings.TrimPrefix(csp, prefix), "'")
+	if nonce == "" {
+		t.Fatalf("could not extract nonce from CSP header %q", csp)
+	}
+
+	if !strings.Contains(rr.Body.String(), `nonce="`+nonce+`"`) {
+		t.Errorf("index.html body %q does not contain the CSP header's nonce %q", rr.Body.String(), nonce)
+	}
+}
+
+func TestOverlayPrecedenceAndFallback(t *testing.T) {
+	base := fstest.MapFS{
+		"favicon.ico": {Data: []byte("base-favicon")},
+		"app.js":      {Data: []byte("base-app")},
+	}
+	overlay := fstest.MapFS{
+		"favicon.ico": {Data: []byte("custom-favicon")},
+	}
+	layers := []assetLayer{newAssetLayer(overlay), newAssetLayer(base)}
+	h := assetHandler(layers, nil, false)
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/favicon.ico", nil))
+	if got := rr.Body.String(); got != "custom-favicon" {
+		t.Errorf("favicon.ico = %q, want the overlay's content %q", got, "custom-favicon")
+	}
+
+	rr2 := httptest.NewRecorder()
+	h.ServeHTTP(rr2, httptest.NewRequest(http.MethodGet, "/app.js", nil))
+	if got := rr2.Body.String(); got != "base-app" {
+		t.Errorf("app.js = %q, want fallback to base layer's content %q", got, "base-app")
+	}
+}
+
+func TestBrowseJSONShape(t *testing.T) {
+	fsys := fstest.MapFS{
+		"plugins/a.js": {Data: []byte("a")},
+		"plugins/b.js": {Data: []byte("bb")},
+	}
+	h := assetHandler([]assetLayer{newAssetLayer(fsys)}, nil, true)
+
+	req := httptest.NewRequest(http.MethodGet, "/plugins", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var entries []assetEntry
+	if err := json.Unmarshal(rr.Body.Bytes(), &entries); err != nil {
+		t.Fatalf("response is not a JSON array of assetEntry: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	if entries[0].Name != "a.js" || entries[0].URL != "/plugins/a.js" || entries[0].Size != 1 {
+		t.Errorf("entries[0] = %+v, want name a.js, url /plugins/a.js, size 1", entries[0])
+	}
+}
+
+func TestSwaggerSpecRenderBaseURLAndTagFilter(t *testing.T) {
+	raw := []byte(`{
+		"paths": {
+			"/public": {
+				"parameters": [{"name": "id"}],
+				"get": {"tags": ["oss"]}
+			},
+			"/enterprise": {
+				"get": {"tags": ["enterprise"]}
+			}
+		}
+	}`)
+	spec := &swaggerSpec{raw: raw}
+
+	body, contentType, err := spec.render("https://example.com", []string{"oss"}, "json")
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if contentType != "application/json; charset=utf-8" {
+		t.Errorf("contentType = %q", contentType)
+	}
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		t.Fatalf("rendered body is not valid JSON: %v", err)
+	}
+
+	servers, _ := doc["servers"].([]interface{})
+	if len(servers) != 1 || servers[0].(map[string]interface{})["url"] != "https://example.com" {
+		t.Errorf("servers = %v, want a single entry with url https://example.com", servers)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	if _, ok := paths["/enterprise"]; ok {
+		t.Error("/enterprise should have been dropped by tag filtering")
+	}
+	public, ok := paths["/public"].(map[string]interface{})
+	if !ok {
+		t.Fatal("/public should remain after tag filtering")
+	}
+	if _, ok := public["parameters"]; !ok {
+		t.Error("/public's non-operation \"parameters\" key should survive tag filtering")
+	}
+	if _, ok := public["get"]; !ok {
+		t.Error("/public's tagged \"get\" operation should survive tag filtering")
+	}
+}
+
+func TestSwaggerRenderCacheProducesStableETag(t *testing.T) {
+	spec := &swaggerSpec{raw: []byte(`{"paths": {}}`)}
+	cache := newSwaggerRenderCache()
+
+	first, err := cache.render(spec, "v2|json|https://example.com", "https://example.com", nil, "json")
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if first.etag == "" {
+		t.Fatal("expected a non-empty ETag")
+	}
+
+	second, err := cache.render(spec, "v2|json|https://example.com", "https://example.com", nil, "json")
+	if err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	if second.etag != first.etag {
+		t.Errorf("ETag changed across cached renders: %q != %q", first.etag, second.etag)
+	}
+}